@@ -0,0 +1,494 @@
+package qrpc
+
+import (
+	"container/heap"
+	"container/list"
+	"encoding/binary"
+	"errors"
+)
+
+// Compression selects how frame payloads are encoded on the wire.
+type Compression int
+
+const (
+	// CompressionNone sends payloads as-is.
+	CompressionNone Compression = iota
+	// CompressionHuffman Huffman-codes each payload independently,
+	// using a canonical code built from that payload's own byte
+	// histogram.
+	CompressionHuffman
+	// CompressionHPACKLike additionally maintains a bounded
+	// per-connection dynamic table of recently seen (cmd, prefix) byte
+	// sequences, HPACK style, referencing repeats by table index with a
+	// Huffman-coded literal tail instead of resending them whole.
+	CompressionHPACKLike
+)
+
+// CompressedFlag marks a frame whose payload (the bytes after the
+// 16-byte header) was compressed by the scheme negotiated via
+// ConnectionConfig.Compression/ServerBinding.Compression. Readers must
+// decompress it before the frame reaches a Handler.
+const CompressedFlag FrameFlag = 1 << 3
+
+// defaultDynamicTableSize is CompressionHPACKLike's table budget when
+// CompressionDynamicTableSize is not configured. No frame on the
+// connection negotiates a different size.
+const defaultDynamicTableSize = 4 << 10 // 4KiB
+
+// dynamicTableEntryOverhead is charged against the table's size budget
+// for each entry in addition to its prefix length, mirroring HPACK's
+// own 32-byte-per-entry accounting so a table of many small entries
+// can't dodge the size limit.
+const dynamicTableEntryOverhead = 32
+
+// IsCompressed reports whether CompressedFlag is set.
+func (f FrameFlag) IsCompressed() bool { return f&CompressedFlag != 0 }
+
+// ToCompressed returns f with CompressedFlag set.
+func (f FrameFlag) ToCompressed() FrameFlag { return f | CompressedFlag }
+
+// ErrBadCompressedFrame is returned by connCompressor.Decompress when a
+// CompressedFlag frame is malformed.
+var ErrBadCompressedFrame = errors.New("qrpc: malformed compressed frame")
+
+// StaticTableEntry is one (cmd, prefix) pair pre-registered into a
+// connCompressor's static table via ServerBinding.CompressionStaticTable
+// / ConnectionConfig.CompressionStaticTable, instead of only being
+// learned into the dynamic table after it's been sent once.
+type StaticTableEntry struct {
+	Cmd    Cmd
+	Prefix []byte
+}
+
+// tableEntry is one (cmd, prefix) pair known to a connCompressor,
+// either registered as a static entry up front or learned into the
+// dynamic table as payloads are sent.
+type tableEntry struct {
+	cmd    Cmd
+	prefix []byte
+}
+
+// connCompressor holds the strictly per-connection state
+// CompressionHPACKLike needs: a bounded dynamic table of recently
+// written (cmd, prefix) sequences, plus a small static table of
+// user-registered common prefixes. Keeping this state per-connection
+// (never persisted or shared) preserves qrpc's stateless-frame
+// invariant across reconnects: a fresh connection always starts with
+// an empty dynamic table.
+type connCompressor struct {
+	mode Compression
+
+	maxDynamicTableSize int
+	dynamicTableSize    int
+	dynamicTable        *list.List // front = most recently inserted *tableEntry
+
+	staticTable []tableEntry
+}
+
+// newConnCompressor constructs the compressor for one connection side.
+// maxDynamicTableSize <= 0 uses defaultDynamicTableSize.
+func newConnCompressor(mode Compression, maxDynamicTableSize int) *connCompressor {
+	if maxDynamicTableSize <= 0 {
+		maxDynamicTableSize = defaultDynamicTableSize
+	}
+	return &connCompressor{
+		mode:                mode,
+		maxDynamicTableSize: maxDynamicTableSize,
+		dynamicTable:        list.New(),
+	}
+}
+
+// RegisterStatic adds a common (cmd, prefix) pair to the static table,
+// available for matching from the first frame onward without needing
+// to be learned into the dynamic table first.
+func (c *connCompressor) RegisterStatic(cmd Cmd, prefix []byte) {
+	c.staticTable = append(c.staticTable, tableEntry{cmd: cmd, prefix: append([]byte(nil), prefix...)})
+}
+
+// Compress returns the on-wire encoding of payload for cmd and whether
+// compression was applied. Callers must fall back to sending payload
+// as-is, without CompressedFlag, whenever ok is false - compression is
+// skipped outright for CompressionNone, and declined by either mode
+// whenever the encoded form would not actually be smaller.
+func (c *connCompressor) Compress(cmd Cmd, payload []byte) (encoded []byte, ok bool) {
+	if c == nil || c.mode == CompressionNone || len(payload) == 0 {
+		return payload, false
+	}
+
+	if c.mode == CompressionHPACKLike {
+		encoded = c.encodeHPACKLike(cmd, payload)
+	} else {
+		encoded = huffmanEncode(payload)
+	}
+
+	if len(encoded) >= len(payload) {
+		return payload, false
+	}
+	if c.mode == CompressionHPACKLike {
+		c.insert(cmd, payload)
+	}
+	return encoded, true
+}
+
+// Decompress reverses Compress for a frame that arrived with
+// CompressedFlag set.
+func (c *connCompressor) Decompress(cmd Cmd, data []byte) ([]byte, error) {
+	if c == nil {
+		return data, nil
+	}
+	if c.mode == CompressionHPACKLike {
+		payload, err := c.decodeHPACKLike(cmd, data)
+		if err != nil {
+			return nil, err
+		}
+		c.insert(cmd, payload)
+		return payload, nil
+	}
+	return huffmanDecode(data)
+}
+
+// encodeHPACKLike emits varint(tableIndex+1) (0 meaning "no match"),
+// then, when an entry matched, varint(matched prefix length), followed
+// by the Huffman-coded literal tail left after the matched prefix.
+func (c *connCompressor) encodeHPACKLike(cmd Cmd, payload []byte) []byte {
+	idx, plen := c.findPrefix(cmd, payload)
+
+	out := appendUvarint(nil, uint64(idx))
+	if idx != 0 {
+		out = appendUvarint(out, uint64(plen))
+	}
+	out = append(out, huffmanEncode(payload[plen:])...)
+	return out
+}
+
+func (c *connCompressor) decodeHPACKLike(cmd Cmd, data []byte) ([]byte, error) {
+	idx, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, ErrBadCompressedFrame
+	}
+	data = data[n:]
+	var plen uint64
+	if idx != 0 {
+		if plen, n = binary.Uvarint(data); n <= 0 {
+			return nil, ErrBadCompressedFrame
+		}
+		data = data[n:]
+	}
+	literal, err := huffmanDecode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if idx == 0 {
+		return literal, nil
+	}
+	prefix := c.entryAt(int(idx) - 1)
+	if prefix == nil || plen > uint64(len(prefix)) {
+		return nil, ErrBadCompressedFrame
+	}
+	return append(append([]byte(nil), prefix[:plen]...), literal...), nil
+}
+
+// findPrefix returns the 1-based table index (0 = none) of the entry
+// for cmd sharing the longest prefix with payload, across both the
+// static and dynamic tables.
+func (c *connCompressor) findPrefix(cmd Cmd, payload []byte) (idx, plen int) {
+	check := func(e tableEntry, at int) {
+		if e.cmd != cmd {
+			return
+		}
+		if n := commonPrefixLen(e.prefix, payload); n > plen {
+			plen, idx = n, at
+		}
+	}
+	for i, e := range c.staticTable {
+		check(e, i+1)
+	}
+	i := len(c.staticTable)
+	for el := c.dynamicTable.Front(); el != nil; el = el.Next() {
+		i++
+		check(*el.Value.(*tableEntry), i)
+	}
+	return
+}
+
+// entryAt returns the prefix bytes for 0-based table index idx,
+// static entries first followed by the dynamic table in insertion
+// order, or nil if idx is out of range.
+func (c *connCompressor) entryAt(idx int) []byte {
+	if idx < len(c.staticTable) {
+		return c.staticTable[idx].prefix
+	}
+	idx -= len(c.staticTable)
+	for el := c.dynamicTable.Front(); el != nil; el = el.Next() {
+		if idx == 0 {
+			return el.Value.(*tableEntry).prefix
+		}
+		idx--
+	}
+	return nil
+}
+
+// insert adds (cmd, payload) to the dynamic table, evicting the
+// oldest entries until the configured size budget is met.
+func (c *connCompressor) insert(cmd Cmd, payload []byte) {
+	size := len(payload) + dynamicTableEntryOverhead
+	if size > c.maxDynamicTableSize {
+		return // a single entry this large could never fit; don't evict everything for nothing
+	}
+
+	for c.dynamicTableSize+size > c.maxDynamicTableSize {
+		back := c.dynamicTable.Back()
+		if back == nil {
+			break
+		}
+		c.dynamicTableSize -= len(back.Value.(*tableEntry).prefix) + dynamicTableEntryOverhead
+		c.dynamicTable.Remove(back)
+	}
+
+	c.dynamicTable.PushFront(&tableEntry{cmd: cmd, prefix: append([]byte(nil), payload...)})
+	c.dynamicTableSize += size
+}
+
+func commonPrefixLen(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+func appendUvarint(dst []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(dst, buf[:n]...)
+}
+
+// --- Huffman coding of literal bytes ---
+//
+// Each call is self-contained: the canonical code is rebuilt from the
+// payload's own byte histogram and its 256 code lengths are written
+// ahead of the packed bitstream, so decoding never depends on state
+// from earlier frames.
+
+type huffmanHeapNode struct {
+	freq        int
+	sym         int // -1 for internal nodes
+	left, right *huffmanHeapNode
+}
+
+type huffmanHeap []*huffmanHeapNode
+
+func (h huffmanHeap) Len() int            { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool  { return h[i].freq < h[j].freq }
+func (h huffmanHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x interface{}) { *h = append(*h, x.(*huffmanHeapNode)) }
+func (h *huffmanHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// huffmanCodeLengths returns, per byte value, the number of bits its
+// canonical Huffman code uses (0 if the byte never appears).
+func huffmanCodeLengths(freq []int) [256]byte {
+	var lengths [256]byte
+
+	h := &huffmanHeap{}
+	for sym, f := range freq {
+		if f > 0 {
+			heap.Push(h, &huffmanHeapNode{freq: f, sym: sym})
+		}
+	}
+	if h.Len() == 0 {
+		return lengths
+	}
+	if h.Len() == 1 {
+		lengths[(*h)[0].sym] = 1
+		return lengths
+	}
+
+	for h.Len() > 1 {
+		a := heap.Pop(h).(*huffmanHeapNode)
+		b := heap.Pop(h).(*huffmanHeapNode)
+		heap.Push(h, &huffmanHeapNode{freq: a.freq + b.freq, sym: -1, left: a, right: b})
+	}
+
+	var walk func(n *huffmanHeapNode, depth byte)
+	walk = func(n *huffmanHeapNode, depth byte) {
+		if n.sym >= 0 {
+			if depth == 0 {
+				depth = 1
+			}
+			lengths[n.sym] = depth
+			return
+		}
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	walk(heap.Pop(h).(*huffmanHeapNode), 0)
+	return lengths
+}
+
+type huffmanCode struct {
+	bits uint32
+	len  byte
+}
+
+// canonicalCodes assigns canonical Huffman codes from code lengths:
+// symbols are ordered by (length, symbol value) and each code is the
+// previous one plus one, shifted for any length increase.
+func canonicalCodes(lengths [256]byte) [256]huffmanCode {
+	type sl struct {
+		sym int
+		len byte
+	}
+	var syms []sl
+	for sym, l := range lengths {
+		if l > 0 {
+			syms = append(syms, sl{sym, l})
+		}
+	}
+	// insertion sort by (len, sym); 256 elements at most, simplicity
+	// over asymptotics is fine here.
+	for i := 1; i < len(syms); i++ {
+		for j := i; j > 0 && (syms[j].len < syms[j-1].len || (syms[j].len == syms[j-1].len && syms[j].sym < syms[j-1].sym)); j-- {
+			syms[j], syms[j-1] = syms[j-1], syms[j]
+		}
+	}
+
+	var codes [256]huffmanCode
+	var code uint32
+	var prevLen byte
+	for _, s := range syms {
+		code <<= (s.len - prevLen)
+		codes[s.sym] = huffmanCode{bits: code, len: s.len}
+		code++
+		prevLen = s.len
+	}
+	return codes
+}
+
+type bitWriter struct {
+	buf  []byte
+	cur  uint64
+	nbit uint
+}
+
+func (w *bitWriter) writeBits(bits uint32, n byte) {
+	w.cur = w.cur<<uint(n) | uint64(bits)
+	w.nbit += uint(n)
+	for w.nbit >= 8 {
+		w.nbit -= 8
+		w.buf = append(w.buf, byte(w.cur>>w.nbit))
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nbit > 0 {
+		w.buf = append(w.buf, byte(w.cur<<(8-w.nbit)))
+	}
+	return w.buf
+}
+
+type bitReader struct {
+	data []byte
+	pos  int // bit position
+}
+
+func (r *bitReader) readBit() (byte, bool) {
+	byteIdx := r.pos / 8
+	if byteIdx >= len(r.data) {
+		return 0, false
+	}
+	bit := (r.data[byteIdx] >> uint(7-r.pos%8)) & 1
+	r.pos++
+	return bit, true
+}
+
+// huffmanEncode returns a self-contained Huffman encoding of src: 256
+// code-length bytes, the varint-encoded symbol count, then the packed
+// bitstream.
+func huffmanEncode(src []byte) []byte {
+	if len(src) == 0 {
+		return nil
+	}
+
+	var freq [256]int
+	for _, b := range src {
+		freq[b]++
+	}
+	lengths := huffmanCodeLengths(freq[:])
+	codes := canonicalCodes(lengths)
+
+	var bw bitWriter
+	for _, b := range src {
+		c := codes[b]
+		bw.writeBits(c.bits, c.len)
+	}
+
+	out := make([]byte, 0, 256+binary.MaxVarintLen64+len(src))
+	out = append(out, lengths[:]...)
+	out = appendUvarint(out, uint64(len(src)))
+	out = append(out, bw.bytes()...)
+	return out
+}
+
+// huffmanDecode reverses huffmanEncode.
+func huffmanDecode(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if len(data) < 256 {
+		return nil, ErrBadCompressedFrame
+	}
+	var lengths [256]byte
+	copy(lengths[:], data[:256])
+
+	n, nn := binary.Uvarint(data[256:])
+	if nn <= 0 {
+		return nil, ErrBadCompressedFrame
+	}
+	bits := data[256+nn:]
+
+	codes := canonicalCodes(lengths)
+	// build a decode trie on the fly: map from (len, bits) -> symbol
+	type key struct {
+		len  byte
+		bits uint32
+	}
+	rev := make(map[key]int, 256)
+	for sym, c := range codes {
+		if c.len > 0 {
+			rev[key{c.len, c.bits}] = sym
+		}
+	}
+
+	out := make([]byte, 0, n)
+	br := bitReader{data: bits}
+	var cur uint32
+	var curLen byte
+	for uint64(len(out)) < n {
+		bit, ok := br.readBit()
+		if !ok {
+			return nil, ErrBadCompressedFrame
+		}
+		cur = cur<<1 | uint32(bit)
+		curLen++
+		if sym, ok := rev[key{curLen, cur}]; ok {
+			out = append(out, byte(sym))
+			cur, curLen = 0, 0
+		}
+		if curLen > 32 {
+			return nil, ErrBadCompressedFrame
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,175 @@
+package qrpc
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestHuffmanRoundTrip(t *testing.T) {
+	cases := [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"),
+		[]byte("the quick brown fox jumps over the lazy dog"),
+		bytes.Repeat([]byte{0xff}, 256),
+	}
+	for _, src := range cases {
+		enc := huffmanEncode(src)
+		got, err := huffmanDecode(enc)
+		if err != nil {
+			t.Fatalf("huffmanDecode: %v", err)
+		}
+		if !bytes.Equal(got, src) && !(len(got) == 0 && len(src) == 0) {
+			t.Fatalf("round trip mismatch: got %q, want %q", got, src)
+		}
+	}
+}
+
+func TestHuffmanRoundTripRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	src := make([]byte, 4096)
+	r.Read(src)
+
+	enc := huffmanEncode(src)
+	got, err := huffmanDecode(enc)
+	if err != nil {
+		t.Fatalf("huffmanDecode: %v", err)
+	}
+	if !bytes.Equal(got, src) {
+		t.Fatal("round trip mismatch on random data")
+	}
+}
+
+func TestConnCompressorHuffmanRoundTrip(t *testing.T) {
+	send := newConnCompressor(CompressionHuffman, 0)
+	recv := newConnCompressor(CompressionHuffman, 0)
+
+	// huffmanEncode always carries a fixed 256-byte code-length table, so
+	// only a payload with enough skewed repetition to amortize that
+	// overhead actually shrinks.
+	payload := bytes.Repeat([]byte("payload payload payload payload "), 100)
+	encoded, ok := send.Compress(1, payload)
+	if !ok {
+		t.Fatal("Compress declined a compressible payload")
+	}
+
+	got, err := recv.Decompress(1, encoded)
+	if err != nil {
+		t.Fatalf("Decompress: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("got %q, want %q", got, payload)
+	}
+}
+
+func TestConnCompressorHPACKLikeLearnsRepeats(t *testing.T) {
+	send := newConnCompressor(CompressionHPACKLike, 0)
+	recv := newConnCompressor(CompressionHPACKLike, 0)
+
+	const cmd Cmd = 7
+	base := bytes.Repeat([]byte("OBJECT_PATH_SEGMENT_"), 30)
+	first := append(append([]byte(nil), base...), []byte("id=000")...)
+	second := append(append([]byte(nil), base...), []byte("id=001")...)
+
+	enc1, ok := send.Compress(cmd, first)
+	if !ok {
+		t.Fatal("Compress declined first payload")
+	}
+	got1, err := recv.Decompress(cmd, enc1)
+	if err != nil {
+		t.Fatalf("Decompress(first): %v", err)
+	}
+	if !bytes.Equal(got1, first) {
+		t.Fatalf("got %q, want %q", got1, first)
+	}
+
+	enc2, ok := send.Compress(cmd, second)
+	if !ok {
+		t.Fatal("Compress declined second payload")
+	}
+	if len(enc2) >= len(enc1) {
+		t.Fatalf("second encoding (%d bytes) should be smaller than the first (%d bytes) once the shared prefix is in the dynamic table", len(enc2), len(enc1))
+	}
+
+	got2, err := recv.Decompress(cmd, enc2)
+	if err != nil {
+		t.Fatalf("Decompress(second): %v", err)
+	}
+	if !bytes.Equal(got2, second) {
+		t.Fatalf("got %q, want %q", got2, second)
+	}
+}
+
+func TestConnCompressorRegisterStaticMatchesFirstFrame(t *testing.T) {
+	send := newConnCompressor(CompressionHPACKLike, 0)
+	recv := newConnCompressor(CompressionHPACKLike, 0)
+
+	const cmd Cmd = 7
+	base := bytes.Repeat([]byte("OBJECT_PATH_SEGMENT_"), 30)
+	send.RegisterStatic(cmd, base)
+	recv.RegisterStatic(cmd, base)
+
+	payload := append(append([]byte(nil), base...), []byte("id=000")...)
+
+	// Nothing has been learned into the dynamic table yet, so the
+	// shared prefix is only found at all because it's in the static
+	// table from construction.
+	wantPlen := len(payload) - 6 // trailing "id=NNN" is the literal tail
+	if idx, plen := send.findPrefix(cmd, payload); idx != 1 || plen != wantPlen {
+		t.Fatalf("findPrefix = (%d, %d), want (1, %d) from the pre-registered static entry", idx, plen, wantPlen)
+	}
+
+	encoded, ok := send.Compress(cmd, payload)
+	if !ok {
+		t.Fatal("Compress declined a payload matching the static table")
+	}
+
+	decoded, err := recv.Decompress(cmd, encoded)
+	if err != nil {
+		t.Fatalf("Decompress: %v", err)
+	}
+	if !bytes.Equal(decoded, payload) {
+		t.Fatalf("got %q, want %q", decoded, payload)
+	}
+}
+
+func TestConnCompressorDynamicTableSizeOverride(t *testing.T) {
+	// A budget too small for even one entry plus its overhead means
+	// nothing ever gets learned, so repeats are never recognized.
+	small := newConnCompressor(CompressionHPACKLike, dynamicTableEntryOverhead)
+	const cmd Cmd = 9
+	payload := bytes.Repeat([]byte("REPEATED_PREFIX_"), 100)
+
+	if _, ok := small.Compress(cmd, payload); !ok {
+		t.Fatal("Compress declined first payload")
+	}
+	if small.dynamicTableSize != 0 {
+		t.Fatalf("dynamicTableSize = %d, want 0: entry should be too large for the configured budget", small.dynamicTableSize)
+	}
+
+	if idx, _ := small.findPrefix(cmd, payload); idx != 0 {
+		t.Fatalf("findPrefix found index %d after a too-large entry should have been rejected", idx)
+	}
+}
+
+func TestConnCompressorDeclinesIncompressible(t *testing.T) {
+	c := newConnCompressor(CompressionHuffman, 0)
+	_, ok := c.Compress(1, []byte{1})
+	if ok {
+		t.Fatal("Compress should decline a payload too small to shrink")
+	}
+}
+
+func TestConnCompressorNilIsNoOp(t *testing.T) {
+	var c *connCompressor
+	payload := []byte("hello")
+	encoded, ok := c.Compress(1, payload)
+	if ok || !bytes.Equal(encoded, payload) {
+		t.Fatalf("nil connCompressor.Compress should pass payload through unchanged")
+	}
+	got, err := c.Decompress(1, payload)
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("nil connCompressor.Decompress should pass data through unchanged")
+	}
+}
@@ -10,6 +10,61 @@ type ServerBinding struct {
 	Handler             Handler // handler to invoke
 	DefaultReadTimeout  int
 	DefaultWriteTimeout int
+
+	// MaxUploadBufferPerConnection is the connection-level flow control
+	// window advertised to peers on this binding, mirroring x/net/http2's
+	// setting of the same name. It is clamped to
+	// [minMaxUploadBufferPerConnection, maxFlowControlWindow]; 0 uses
+	// defaultMaxUploadBufferPerConnection. A negative value disables flow
+	// control entirely for the connection.
+	MaxUploadBufferPerConnection int32
+
+	// MaxUploadBufferPerStream is the per-stream flow control window
+	// advertised to peers on this binding, clamped the same way as
+	// MaxUploadBufferPerConnection; 0 uses defaultMaxUploadBufferPerStream.
+	// Only meaningful when MaxUploadBufferPerConnection >= 0.
+	MaxUploadBufferPerStream int32
+
+	// NewWriteScheduler, if set, constructs the WriteScheduler used to
+	// order outbound frames on each connection accepted by this binding.
+	// Defaults to NewRandomWriteScheduler.
+	NewWriteScheduler func() WriteScheduler
+
+	// IdleTimeout, in ms, closes a connection that has no open streams
+	// and has seen no frames for the interval. <= 0 disables it.
+	IdleTimeout int
+
+	// ReadIdleTimeout, in ms, is how long to wait for any inbound frame
+	// before sending a PING to check the connection is still alive. <= 0
+	// disables keepalive PINGs.
+	ReadIdleTimeout int
+
+	// PingTimeout, in ms, is how long to wait for a PING ack before
+	// closing the connection. Only meaningful when ReadIdleTimeout > 0.
+	PingTimeout int
+
+	// Compression selects the opt-in per-connection payload compression
+	// scheme for connections accepted by this binding. Defaults to
+	// CompressionNone. Must match the peer's ConnectionConfig.Compression.
+	Compression Compression
+
+	// CompressionDynamicTableSize overrides CompressionHPACKLike's
+	// per-connection dynamic table budget (defaultDynamicTableSize in
+	// compression.go when <= 0). There is no in-band SETTINGS-equivalent
+	// frame in this connection's handshake to renegotiate it later, so
+	// this must be configured to the same value as the peer's
+	// ConnectionConfig.CompressionDynamicTableSize out-of-band, the same
+	// way Compression itself must match.
+	CompressionDynamicTableSize int
+
+	// CompressionStaticTable is registered into each connection's
+	// connCompressor via RegisterStatic before it handles any frame,
+	// letting CompressionHPACKLike recognize common (cmd, prefix) pairs
+	// from the first frame rather than only after they've been learned
+	// into the dynamic table. Table indices are positional, so this must
+	// list the same entries in the same order as the peer's
+	// ConnectionConfig.CompressionStaticTable.
+	CompressionStaticTable []StaticTableEntry
 }
 
 // SubFunc for subscribe callback
@@ -20,4 +75,30 @@ type ConnectionConfig struct {
 	Ctx          context.Context
 	WriteTimeout int
 	ReadTimeout  int
+
+	// MaxUploadBufferPerConnection is the client-side counterpart of
+	// ServerBinding.MaxUploadBufferPerConnection. No client connection
+	// code reads it: dialed connections have no flow control windows.
+	MaxUploadBufferPerConnection int32
+
+	// MaxUploadBufferPerStream is the client-side counterpart of
+	// ServerBinding.MaxUploadBufferPerStream. No client connection code
+	// reads it.
+	MaxUploadBufferPerStream int32
+
+	// Compression is the client-side counterpart of
+	// ServerBinding.Compression. No client connection code reads it:
+	// dialed connections neither compress payloads nor decompress
+	// CompressedFlag frames.
+	Compression Compression
+
+	// CompressionDynamicTableSize is the client-side counterpart of
+	// ServerBinding.CompressionDynamicTableSize. No client connection
+	// code reads it.
+	CompressionDynamicTableSize int
+
+	// CompressionStaticTable is the client-side counterpart of
+	// ServerBinding.CompressionStaticTable. No client connection code
+	// reads it.
+	CompressionStaticTable []StaticTableEntry
 }
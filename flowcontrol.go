@@ -0,0 +1,244 @@
+package qrpc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+)
+
+const (
+	// defaultMaxUploadBufferPerConnection is used when
+	// ServerBinding/ConnectionConfig leaves MaxUploadBufferPerConnection
+	// unset.
+	defaultMaxUploadBufferPerConnection = 1 << 20 // 1MB
+
+	// defaultMaxUploadBufferPerStream is used when
+	// ServerBinding/ConnectionConfig leaves MaxUploadBufferPerStream
+	// unset.
+	defaultMaxUploadBufferPerStream = 1 << 20 // 1MB
+
+	minMaxUploadBufferPerConnection = 64 << 10 // 64KB, same floor x/net/http2 enforces
+	minMaxUploadBufferPerStream     = 64 << 10
+	maxFlowControlWindow            = math.MaxInt32
+)
+
+// ErrFlowControl is returned when a WINDOW_UPDATE would overflow a
+// window back into negative/overflowed territory.
+var ErrFlowControl = errors.New("qrpc: flow control window overflow")
+
+// FlowControlError is the reset reason sent when a stream-level
+// WINDOW_UPDATE would overflow that stream's send window past int32; an
+// overflowing connection-level WINDOW_UPDATE closes the connection
+// instead. Inbound frames are not counted against a receive window, so
+// a peer writing more than it was granted is not detected.
+const FlowControlError Cmd = 0xfffe
+
+// WindowUpdateCmd carries a uint32 credit delta that replenishes the
+// send window for a stream (RequestID != 0) or the whole connection
+// (RequestID == 0). See flowWindow and serveconn's send/recv window
+// bookkeeping.
+const WindowUpdateCmd Cmd = 0xffff
+
+// WindowUpdateFlag marks a frame as carrying window credit rather than
+// handler payload; such frames are consumed by readFrames and never
+// reach the Handler.
+const WindowUpdateFlag FrameFlag = 1 << 7
+
+// ManualWindowUpdateFlag, set by a handler on its response frame, opts
+// that stream out of automatic flow-control credit return so a
+// streaming handler can throttle the peer by returning credit itself
+// (via serveconn.writeWindowUpdate) whenever it chooses.
+const ManualWindowUpdateFlag FrameFlag = 1 << 6
+
+// IsManualWindowUpdate reports whether ManualWindowUpdateFlag is set.
+func (f FrameFlag) IsManualWindowUpdate() bool { return f&ManualWindowUpdateFlag != 0 }
+
+// clampWindowSize enforces [min, maxFlowControlWindow] on a
+// user-configured window size, substituting def when v == 0. Callers
+// are expected to have already handled v < 0 (flow control disabled)
+// before reaching here.
+func clampWindowSize(v int32, def, min int32) int32 {
+	if v == 0 {
+		v = def
+	}
+	if v < min {
+		v = min
+	}
+	if v > maxFlowControlWindow {
+		v = maxFlowControlWindow
+	}
+	return v
+}
+
+// flowWindow is a blocking, signed byte counter used to implement
+// HTTP/2-style flow control for a single stream or an entire
+// connection. A negative size can happen transiently when a peer
+// shrinks the window it had already granted; Take simply waits longer.
+type flowWindow struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	size int32
+}
+
+func newFlowWindow(initial int32) *flowWindow {
+	w := &flowWindow{size: initial}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+// Take blocks until at least n bytes of window are available, then
+// deducts n. It returns early with ctx.Err() if ctx is done first.
+func (w *flowWindow) Take(ctx context.Context, n int32) error {
+	if n <= 0 {
+		return nil
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.cond.Broadcast()
+		case <-stop:
+		}
+	}()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.size < n {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		w.cond.Wait()
+	}
+	w.size -= n
+	return nil
+}
+
+// Add grants n additional bytes of credit and wakes any writer blocked
+// in Take. It returns ErrFlowControl if the window would overflow
+// int32, matching HTTP/2's FLOW_CONTROL_ERROR.
+func (w *flowWindow) Add(n int32) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if n > 0 && w.size > math.MaxInt32-n {
+		return ErrFlowControl
+	}
+	w.size += n
+	w.cond.Broadcast()
+	return nil
+}
+
+// writeWindowUpdate emits a WINDOW_UPDATE frame granting delta bytes of
+// credit back to the peer for requestID (0 means connection-level).
+func (sc *serveconn) writeWindowUpdate(requestID uint64, delta uint32) error {
+	if delta == 0 {
+		return nil
+	}
+	w := sc.GetWriter()
+	w.StartWrite(requestID, WindowUpdateCmd, WindowUpdateFlag)
+	w.WriteUint32(delta)
+	return w.EndWrite()
+}
+
+// returnFlowControlCredit grants back the connection and (for
+// non-control frames) stream window consumed by f's payload, unless the
+// handler already wrote a response for this stream with
+// ManualWindowUpdateFlag set, opting into manual credit management via
+// serveconn.writeWindowUpdate instead.
+func (sc *serveconn) returnFlowControlCredit(f *RequestFrame) {
+	if sc.connRecvWindow == nil || sc.hasManualCredit(f.RequestID) {
+		return
+	}
+	n := uint32(len(f.Payload))
+	if n == 0 {
+		return
+	}
+	if f.RequestID != 0 {
+		if err := sc.writeWindowUpdate(f.RequestID, n); err != nil {
+			LogDebug("writeWindowUpdate", err, sc.rwc.RemoteAddr().String())
+		}
+	}
+	if err := sc.writeWindowUpdate(0, n); err != nil {
+		LogDebug("writeWindowUpdate conn", err, sc.rwc.RemoteAddr().String())
+	}
+}
+
+// handleWindowUpdate applies credit carried by a WINDOW_UPDATE frame to
+// the matching connection or stream send window, RSTing the stream with
+// FlowControlError on overflow.
+func (sc *serveconn) handleWindowUpdate(req *RequestFrame) {
+	if len(req.Payload) < 4 {
+		return
+	}
+	delta := int32(binary.BigEndian.Uint32(req.Payload))
+
+	if req.RequestID == 0 {
+		if sc.connSendWindow != nil && sc.connSendWindow.Add(delta) != nil {
+			sc.Close()
+		}
+		return
+	}
+
+	sc.windowsMu.Lock()
+	w, ok := sc.streamSendWindows[req.RequestID]
+	sc.windowsMu.Unlock()
+	if !ok {
+		return
+	}
+	if w.Add(delta) != nil {
+		writer := sc.GetWriter()
+		_ = writer.ResetFrame(req.RequestID, FlowControlError)
+	}
+}
+
+// streamSendWindow returns (creating if needed) the send window for
+// requestID.
+func (sc *serveconn) streamSendWindow(requestID uint64) *flowWindow {
+	sc.windowsMu.Lock()
+	defer sc.windowsMu.Unlock()
+	w, ok := sc.streamSendWindows[requestID]
+	if !ok {
+		w = newFlowWindow(sc.initialStreamSendWindow)
+		sc.streamSendWindows[requestID] = w
+	}
+	return w
+}
+
+// releaseStreamSendWindow drops bookkeeping for a finished stream.
+func (sc *serveconn) releaseStreamSendWindow(requestID uint64) {
+	sc.windowsMu.Lock()
+	delete(sc.streamSendWindows, requestID)
+	delete(sc.manualCreditStreams, requestID)
+	sc.windowsMu.Unlock()
+}
+
+// hasManualCredit reports whether requestID's handler has written a
+// response with ManualWindowUpdateFlag set, opting that stream out of
+// returnFlowControlCredit's automatic credit return.
+func (sc *serveconn) hasManualCredit(requestID uint64) bool {
+	sc.windowsMu.Lock()
+	defer sc.windowsMu.Unlock()
+	_, ok := sc.manualCreditStreams[requestID]
+	return ok
+}
+
+// takeSendWindow blocks until both the connection and the requestID's
+// stream have room for an n-byte payload, then deducts n from both.
+func (sc *serveconn) takeSendWindow(requestID uint64, n int32) error {
+	if sc.connSendWindow == nil || n <= 0 {
+		return nil
+	}
+	if err := sc.connSendWindow.Take(sc.ctx, n); err != nil {
+		return err
+	}
+	if err := sc.streamSendWindow(requestID).Take(sc.ctx, n); err != nil {
+		_ = sc.connSendWindow.Add(n) // give back what we already spent
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package qrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClampWindowSize(t *testing.T) {
+	cases := []struct {
+		name string
+		v    int32
+		want int32
+	}{
+		{"zero uses default", 0, 1 << 20},
+		{"below min clamps up", 1024, minMaxUploadBufferPerConnection},
+		{"within range passes through", 1 << 21, 1 << 21},
+		{"max passes through", maxFlowControlWindow, maxFlowControlWindow},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := clampWindowSize(c.v, 1<<20, minMaxUploadBufferPerConnection)
+			if got != c.want {
+				t.Fatalf("clampWindowSize(%d) = %d, want %d", c.v, got, c.want)
+			}
+		})
+	}
+}
+
+func TestFlowWindowTakeAdd(t *testing.T) {
+	w := newFlowWindow(10)
+
+	if err := w.Take(context.Background(), 6); err != nil {
+		t.Fatalf("Take(6): %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Take(context.Background(), 8)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Take returned before enough credit was available")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if err := w.Add(8); err != nil {
+		t.Fatalf("Add(8): %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Take after Add: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Take still blocked after enough credit was added")
+	}
+}
+
+func TestFlowWindowTakeCtxCancel(t *testing.T) {
+	w := newFlowWindow(0)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() { done <- w.Take(ctx, 1) }()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("Take error = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Take did not return after ctx cancel")
+	}
+}
+
+func TestFlowWindowAddOverflow(t *testing.T) {
+	w := newFlowWindow(maxFlowControlWindow)
+	if err := w.Add(1); err != ErrFlowControl {
+		t.Fatalf("Add overflow error = %v, want ErrFlowControl", err)
+	}
+}
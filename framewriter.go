@@ -1,5 +1,10 @@
 package qrpc
 
+// frameHeaderLen is the fixed size, in bytes, of the frame header
+// written by StartWrite/EndWrite: 4 (length) + 8 (requestID) + 1 (flags)
+// + 3 (cmd).
+const frameHeaderLen = 16
+
 // FrameBytesWriter for writing frame bytes
 type FrameBytesWriter interface {
 	// writeFrame write a frame atomically or error
@@ -14,6 +19,20 @@ type defaultFrameWriter struct {
 	requestID uint64
 	cmd       Cmd
 	flags     FrameFlag
+	priority  *PriorityParam
+}
+
+// SetPriority requests that the stream being opened by the next
+// StartWrite/EndWrite be scheduled with the given PriorityParam. It is
+// only meaningful on the frame that opens a stream; callers that don't
+// care about scheduling order can leave it unset.
+func (dfw *defaultFrameWriter) SetPriority(p PriorityParam) {
+	dfw.priority = &p
+}
+
+// Priority returns the PriorityParam set via SetPriority, if any.
+func (dfw *defaultFrameWriter) Priority() *PriorityParam {
+	return dfw.priority
 }
 
 // newFrameWriter creates a FrameWriter instance to write frames
@@ -0,0 +1,210 @@
+package qrpc
+
+import (
+	"context"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+// PingCmd carries an 8-byte opaque payload. A plain PING (PingFlag set)
+// must be answered with the same payload echoed back in a PING ack
+// (PingFlag|PingAckFlag set); acks are consumed by readFrames and never
+// reach the Handler.
+const PingCmd Cmd = 0xfff9
+
+// PingFlag marks a frame as a PING.
+const PingFlag FrameFlag = 1 << 5
+
+// PingAckFlag, combined with PingFlag, marks a PING as the
+// acknowledgement of an earlier one rather than a new liveness probe.
+const PingAckFlag FrameFlag = 1 << 4
+
+// IsPingAck reports whether PingAckFlag is set.
+func (f FrameFlag) IsPingAck() bool { return f&PingAckFlag != 0 }
+
+// Ping sends a PING frame and blocks until the peer acks it, returning
+// the measured round-trip time. It can be used by applications to
+// measure liveness and feed adaptive timeouts.
+func (sc *serveconn) Ping(ctx context.Context) (time.Duration, error) {
+	var payload [8]byte
+	id := uint64(PoorManUUID(false))
+	binary.BigEndian.PutUint64(payload[:], id)
+
+	ch := make(chan time.Time, 1)
+	sc.pingMu.Lock()
+	if sc.pingWaiters == nil {
+		sc.pingWaiters = make(map[uint64]chan time.Time)
+	}
+	sc.pingWaiters[id] = ch
+	sc.pingMu.Unlock()
+
+	start := time.Now()
+	w := sc.GetWriter()
+	w.StartWrite(0, PingCmd, PingFlag)
+	w.WriteBytes(payload[:])
+	if err := w.EndWrite(); err != nil {
+		sc.pingMu.Lock()
+		delete(sc.pingWaiters, id)
+		sc.pingMu.Unlock()
+		return 0, err
+	}
+
+	select {
+	case t := <-ch:
+		return t.Sub(start), nil
+	case <-ctx.Done():
+		sc.pingMu.Lock()
+		delete(sc.pingWaiters, id)
+		sc.pingMu.Unlock()
+		return 0, ctx.Err()
+	case <-sc.ctx.Done():
+		sc.pingMu.Lock()
+		delete(sc.pingWaiters, id)
+		sc.pingMu.Unlock()
+		return 0, sc.ctx.Err()
+	}
+}
+
+// handlePing answers an inbound PING immediately, or resolves the
+// keepalive/Ping waiter for an inbound PING ack. Called from readFrames
+// instead of dispatching to the Handler.
+func (sc *serveconn) handlePing(f *RequestFrame) {
+	if len(f.Payload) < 8 {
+		return
+	}
+
+	if f.Flags.IsPingAck() {
+		id := binary.BigEndian.Uint64(f.Payload)
+
+		sc.pingMu.Lock()
+		if timer, ok := sc.pingTimers[id]; ok {
+			timer.Stop()
+			delete(sc.pingTimers, id)
+		}
+		ch, ok := sc.pingWaiters[id]
+		if ok {
+			delete(sc.pingWaiters, id)
+		}
+		sc.pingMu.Unlock()
+
+		if ok {
+			select {
+			case ch <- time.Now():
+			default:
+			}
+		}
+		return
+	}
+
+	w := sc.GetWriter()
+	w.StartWrite(0, PingCmd, PingFlag|PingAckFlag)
+	w.WriteBytes(f.Payload)
+	if err := w.EndWrite(); err != nil {
+		LogDebug("ping ack", err, sc.RemoteAddr())
+	}
+}
+
+// sendKeepAlivePing is the automatic PING sent by keepAliveLoop after
+// ReadIdleTimeout of read silence; unlike Ping it doesn't block the
+// caller, instead closing the connection itself if PingTimeout elapses
+// with no ack.
+func (sc *serveconn) sendKeepAlivePing(pingTimeout time.Duration) {
+	var payload [8]byte
+	id := uint64(PoorManUUID(false))
+	binary.BigEndian.PutUint64(payload[:], id)
+
+	w := sc.GetWriter()
+	w.StartWrite(0, PingCmd, PingFlag)
+	w.WriteBytes(payload[:])
+	if err := w.EndWrite(); err != nil {
+		LogDebug("keepalive ping", err, sc.RemoteAddr())
+		return
+	}
+
+	timer := time.AfterFunc(pingTimeout, func() {
+		LogError("ping timeout, closing connection", sc.RemoteAddr())
+		sc.Close()
+	})
+
+	sc.pingMu.Lock()
+	if sc.pingTimers == nil {
+		sc.pingTimers = make(map[uint64]*time.Timer)
+	}
+	sc.pingTimers[id] = timer
+	sc.pingMu.Unlock()
+}
+
+// stopPingTimers cancels every outstanding sendKeepAlivePing timeout
+// timer, so a connection closed for some other reason (e.g. idle
+// timeout, peer reset) doesn't leave them to fire later against an
+// already-closed sc. Called from closeUntracked.
+func (sc *serveconn) stopPingTimers() {
+	sc.pingMu.Lock()
+	defer sc.pingMu.Unlock()
+	for id, timer := range sc.pingTimers {
+		timer.Stop()
+		delete(sc.pingTimers, id)
+	}
+}
+
+// noteActivity records that a frame was just read or written, for
+// keepAliveLoop's idle/read-idle bookkeeping.
+func (sc *serveconn) noteActivity() {
+	atomic.StoreInt64(&sc.lastActivityNs, time.Now().UnixNano())
+}
+
+func (sc *serveconn) noteRead() {
+	now := time.Now().UnixNano()
+	atomic.StoreInt64(&sc.lastActivityNs, now)
+	atomic.StoreInt64(&sc.lastReadNs, now)
+}
+
+// keepAliveLoop implements ServerBinding.IdleTimeout and
+// ReadIdleTimeout/PingTimeout. It exits when the connection closes or
+// neither timeout is configured.
+func (sc *serveconn) keepAliveLoop(binding *ServerBinding) {
+	if binding.IdleTimeout <= 0 && binding.ReadIdleTimeout <= 0 {
+		return
+	}
+
+	interval := time.Duration(binding.ReadIdleTimeout) * time.Millisecond
+	if idle := time.Duration(binding.IdleTimeout) * time.Millisecond; interval <= 0 || (idle > 0 && idle < interval) {
+		interval = idle
+	}
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sc.ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now()
+
+			if binding.IdleTimeout > 0 && atomic.LoadInt32(&sc.activeStreams) == 0 {
+				last := time.Unix(0, atomic.LoadInt64(&sc.lastActivityNs))
+				if now.Sub(last) >= time.Duration(binding.IdleTimeout)*time.Millisecond {
+					LogDebug("idle timeout, closing connection", sc.RemoteAddr())
+					sc.Close()
+					return
+				}
+			}
+
+			if binding.ReadIdleTimeout > 0 {
+				last := time.Unix(0, atomic.LoadInt64(&sc.lastReadNs))
+				if now.Sub(last) >= time.Duration(binding.ReadIdleTimeout)*time.Millisecond {
+					pingTimeout := time.Duration(binding.PingTimeout) * time.Millisecond
+					if pingTimeout <= 0 {
+						pingTimeout = 15 * time.Second
+					}
+					sc.sendKeepAlivePing(pingTimeout)
+				}
+			}
+		}
+	}
+}
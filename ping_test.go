@@ -0,0 +1,61 @@
+package qrpc
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStopPingTimersCancelsAllAndClearsMap(t *testing.T) {
+	sc := &serveconn{}
+
+	var fired int32
+	sc.pingTimers = map[uint64]*time.Timer{
+		1: time.AfterFunc(50*time.Millisecond, func() { atomic.AddInt32(&fired, 1) }),
+		2: time.AfterFunc(50*time.Millisecond, func() { atomic.AddInt32(&fired, 1) }),
+	}
+
+	sc.stopPingTimers()
+
+	if len(sc.pingTimers) != 0 {
+		t.Fatalf("pingTimers = %v, want empty after stopPingTimers", sc.pingTimers)
+	}
+
+	time.Sleep(75 * time.Millisecond)
+	if atomic.LoadInt32(&fired) != 0 {
+		t.Fatal("a timer fired after stopPingTimers should have stopped it")
+	}
+}
+
+func TestNoteReadUpdatesBothActivityAndReadTimestamps(t *testing.T) {
+	sc := &serveconn{}
+
+	before := time.Now().UnixNano()
+	sc.noteRead()
+	after := time.Now().UnixNano()
+
+	activity := atomic.LoadInt64(&sc.lastActivityNs)
+	read := atomic.LoadInt64(&sc.lastReadNs)
+	if activity < before || activity > after {
+		t.Fatalf("lastActivityNs = %d, want within [%d, %d]", activity, before, after)
+	}
+	if read < before || read > after {
+		t.Fatalf("lastReadNs = %d, want within [%d, %d]", read, before, after)
+	}
+}
+
+func TestNoteActivityLeavesReadTimestampAlone(t *testing.T) {
+	sc := &serveconn{}
+
+	const stalePast = int64(1000)
+	atomic.StoreInt64(&sc.lastReadNs, stalePast)
+
+	sc.noteActivity()
+
+	if got := atomic.LoadInt64(&sc.lastReadNs); got != stalePast {
+		t.Fatalf("lastReadNs = %d, want unchanged at %d: noteActivity should only bump lastActivityNs", got, stalePast)
+	}
+	if got := atomic.LoadInt64(&sc.lastActivityNs); got <= stalePast {
+		t.Fatalf("lastActivityNs = %d, want updated to roughly now", got)
+	}
+}
@@ -0,0 +1,307 @@
+package qrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// RevDialNewConnCmd is sent by a RevDialer to the peer running a
+// RevDialListener to announce a new logical sub-connection. The frame's
+// RequestID is the sub-conn id used by all subsequent
+// RevDialDataCmd/RevDialCloseCmd frames for it.
+const RevDialNewConnCmd Cmd = 0xfffd
+
+// RevDialCloseCmd is sent in either direction to tear down the
+// sub-connection named by the frame's RequestID.
+const RevDialCloseCmd Cmd = 0xfffc
+
+// RevDialDataCmd carries sub-connection payload in either direction,
+// addressed by the frame's RequestID.
+const RevDialDataCmd Cmd = 0xfffb
+
+// ErrRevDialClosed is returned by a revdial net.Conn or net.Listener
+// once it has been closed.
+var ErrRevDialClosed = errors.New("qrpc/revdial: closed")
+
+// NewRevDialListener turns sc, the server side of an accepted qrpc
+// connection, into a net.Listener: whenever the peer at the other end
+// of sc opens a logical sub-connection (by sending RevDialNewConnCmd),
+// it surfaces here as an accepted net.Conn. This is the accepting half
+// of the reverse-dial pattern; the process that dialed the qrpc
+// connection opens the sub-connections with a RevDialer.
+func NewRevDialListener(sc *serveconn) net.Listener {
+	l := &revDialListener{
+		sc:       sc,
+		acceptCh: make(chan *revDialConn, 16),
+		closed:   make(chan struct{}),
+		conns:    make(map[uint64]*revDialConn),
+	}
+	sc.revDialListener = l
+	return l
+}
+
+type revDialListener struct {
+	sc       *serveconn
+	acceptCh chan *revDialConn
+
+	mu       sync.Mutex
+	conns    map[uint64]*revDialConn
+	closed   chan struct{}
+	closeErr error
+}
+
+func (l *revDialListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.acceptCh:
+		return c, nil
+	case <-l.closed:
+		return nil, ErrRevDialClosed
+	}
+}
+
+func (l *revDialListener) Addr() net.Addr { return l.sc.rwc.LocalAddr() }
+
+func (l *revDialListener) Close() error {
+	l.mu.Lock()
+	select {
+	case <-l.closed:
+		l.mu.Unlock()
+		return nil
+	default:
+		close(l.closed)
+	}
+	conns := make([]*revDialConn, 0, len(l.conns))
+	for _, c := range l.conns {
+		conns = append(conns, c)
+	}
+	l.mu.Unlock()
+
+	for _, c := range conns {
+		c.closeLocal(ErrRevDialClosed)
+	}
+	return nil
+}
+
+// dispatch routes a RevDial* frame read from sc to the relevant
+// sub-conn (or, for RevDialNewConnCmd, creates one and hands it to
+// Accept). Called from serveconn.readFrames instead of delivering the
+// frame to the user Handler. id is the frame's full RequestID: it must
+// stay a uint64 end to end, since revDialer.Dial (the client side)
+// keys its conns map by the same untruncated RequestID.
+func (l *revDialListener) dispatch(cmd Cmd, id uint64, payload []byte) {
+	switch cmd {
+	case RevDialNewConnCmd:
+		c := newRevDialConn(l.sc, id)
+		l.mu.Lock()
+		l.conns[id] = c
+		l.mu.Unlock()
+		select {
+		case l.acceptCh <- c:
+		case <-l.closed:
+			c.closeLocal(ErrRevDialClosed)
+		}
+	case RevDialDataCmd:
+		if c := l.get(id); c != nil {
+			c.deliver(payload)
+		}
+	case RevDialCloseCmd:
+		if c := l.get(id); c != nil {
+			c.closeLocal(io.EOF)
+		}
+	}
+}
+
+func (l *revDialListener) get(id uint64) *revDialConn {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.conns[id]
+}
+
+func (l *revDialListener) forget(id uint64) {
+	l.mu.Lock()
+	delete(l.conns, id)
+	l.mu.Unlock()
+}
+
+// revDialConn is a net.Conn backed by RevDialDataCmd/RevDialCloseCmd
+// frames multiplexed over a single qrpc connection. Its requestID in
+// the parent qrpc stream namespace is its sub-conn id, so Write blocks
+// on the parent connection's existing flow-control window (see
+// flowcontrol.go) rather than reinventing a second layer of credit
+// accounting, which is what made revdial v1 deadlock-prone upstream.
+type revDialConn struct {
+	id uint64
+	sc *serveconn
+
+	pr *io.PipeReader
+	pw *io.PipeWriter
+
+	closeOnce sync.Once
+}
+
+func newRevDialConn(sc *serveconn, id uint64) *revDialConn {
+	pr, pw := io.Pipe()
+	return &revDialConn{id: id, sc: sc, pr: pr, pw: pw}
+}
+
+func (c *revDialConn) deliver(payload []byte) {
+	// best-effort: a blocked Read will consume it; a reader that never
+	// arrives (closed conn) makes this a no-op once pw is closed.
+	_, _ = c.pw.Write(payload)
+}
+
+func (c *revDialConn) Read(b []byte) (int, error)      { return c.pr.Read(b) }
+func (c *revDialConn) LocalAddr() net.Addr             { return c.sc.rwc.LocalAddr() }
+func (c *revDialConn) RemoteAddr() net.Addr            { return c.sc.rwc.RemoteAddr() }
+func (c *revDialConn) SetDeadline(time.Time) error     { return nil }
+func (c *revDialConn) SetReadDeadline(time.Time) error { return nil }
+func (c *revDialConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+func (c *revDialConn) Write(b []byte) (int, error) {
+	w := c.sc.GetWriter()
+	w.StartWrite(c.id, RevDialDataCmd, 0)
+	w.WriteBytes(b)
+	if err := w.EndWrite(); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// closeLocal unblocks Read with err without notifying the peer; used
+// when the peer has already told us (RevDialCloseCmd) or the listener
+// is shutting down.
+func (c *revDialConn) closeLocal(err error) {
+	c.closeOnce.Do(func() {
+		_ = c.pw.CloseWithError(err)
+		if l := c.sc.revDialListener; l != nil {
+			l.forget(c.id)
+		}
+	})
+}
+
+func (c *revDialConn) Close() error {
+	w := c.sc.GetWriter()
+	w.StartWrite(c.id, RevDialCloseCmd, 0)
+	err := w.EndWrite()
+	c.closeLocal(ErrRevDialClosed)
+	return err
+}
+
+// RevDialer dials logical sub-connections back through a qrpc
+// connection established in the other direction, the client half of
+// the reverse-dial pattern.
+type RevDialer interface {
+	Dial(ctx context.Context) (net.Conn, error)
+}
+
+// NewRevDialer returns a RevDialer that multiplexes sub-conns over cc,
+// the qrpc connection a NAT'd process dialed out with. Each Dial asks
+// the peer (the public side, running a RevDialListener) to accept a new
+// sub-conn, then returns a net.Conn for it.
+func NewRevDialer(cc ClientConn) RevDialer {
+	return &revDialer{cc: cc}
+}
+
+type revDialer struct {
+	cc ClientConn
+
+	mu    sync.Mutex
+	conns map[uint64]*revDialClientConn
+}
+
+func (d *revDialer) Dial(ctx context.Context) (net.Conn, error) {
+	c := &revDialClientConn{cc: d.cc}
+	c.pr, c.pw = io.Pipe()
+
+	requestID, _, err := d.cc.Request(RevDialNewConnCmd, NBFlag, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.requestID = requestID
+
+	d.mu.Lock()
+	if d.conns == nil {
+		d.conns = make(map[uint64]*revDialClientConn)
+	}
+	d.conns[requestID] = c
+	d.mu.Unlock()
+
+	return c, nil
+}
+
+// Dispatch routes a RevDialDataCmd/RevDialCloseCmd frame received on cc
+// to the matching sub-conn returned by an earlier Dial. It is the
+// client-side counterpart of revDialListener.dispatch; wire it into
+// ClientConn's frame routing (e.g. via Subscribe) for the cmds above.
+func (d *revDialer) Dispatch(cmd Cmd, requestID uint64, payload []byte) {
+	d.mu.Lock()
+	c := d.conns[requestID]
+	d.mu.Unlock()
+	if c == nil {
+		return
+	}
+	switch cmd {
+	case RevDialDataCmd:
+		_, _ = c.pw.Write(payload)
+	case RevDialCloseCmd:
+		c.closeLocal(io.EOF)
+		d.mu.Lock()
+		delete(d.conns, requestID)
+		d.mu.Unlock()
+	}
+}
+
+// revDialClientConn is the dialer-side counterpart of revDialConn; it
+// writes using the client's own RequestID rather than a sub-conn id
+// because the server side correlates sub-conns by the stream the
+// RevDialNewConnCmd frame opened.
+type revDialClientConn struct {
+	requestID uint64
+	cc        ClientConn
+
+	pr *io.PipeReader
+	pw *io.PipeWriter
+
+	closeOnce sync.Once
+}
+
+func (c *revDialClientConn) Read(b []byte) (int, error)       { return c.pr.Read(b) }
+func (c *revDialClientConn) LocalAddr() net.Addr              { return nil }
+func (c *revDialClientConn) RemoteAddr() net.Addr             { return nil }
+func (c *revDialClientConn) SetDeadline(time.Time) error      { return nil }
+func (c *revDialClientConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *revDialClientConn) SetWriteDeadline(time.Time) error { return nil }
+
+func (c *revDialClientConn) Write(b []byte) (int, error) {
+	w := c.cc.GetWriter()
+	w.StartWrite(c.requestID, RevDialDataCmd, 0)
+	w.WriteBytes(b)
+	if err := w.EndWrite(); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *revDialClientConn) Close() error {
+	w := c.cc.GetWriter()
+	w.StartWrite(c.requestID, RevDialCloseCmd, 0)
+	err := w.EndWrite()
+	c.closeLocal(ErrRevDialClosed)
+	return err
+}
+
+func (c *revDialClientConn) closeLocal(err error) {
+	c.closeOnce.Do(func() {
+		_ = c.pw.CloseWithError(err)
+	})
+}
+
+func uint32ToBytes(v uint32) []byte {
+	return []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
@@ -0,0 +1,112 @@
+package qrpc
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func newTestRevDialListener() *revDialListener {
+	sc := &serveconn{}
+	l := &revDialListener{
+		sc:       sc,
+		acceptCh: make(chan *revDialConn, 16),
+		closed:   make(chan struct{}),
+		conns:    make(map[uint64]*revDialConn),
+	}
+	sc.revDialListener = l
+	return l
+}
+
+// TestRevDialListenerFullRequestIDNotTruncated guards against
+// regressing to a 32-bit sub-conn id: two sub-conns whose RequestIDs
+// share the same low 32 bits but differ in the high bits must be
+// tracked as distinct connections.
+func TestRevDialListenerFullRequestIDNotTruncated(t *testing.T) {
+	l := newTestRevDialListener()
+
+	const idA uint64 = 0x0000000100000001
+	const idB uint64 = 0x0000000200000001 // same low 32 bits as idA
+
+	l.dispatch(RevDialNewConnCmd, idA, nil)
+	l.dispatch(RevDialNewConnCmd, idB, nil)
+
+	connA := acceptRevDialConn(t, l)
+	connB := acceptRevDialConn(t, l)
+	if connA.id == connB.id {
+		t.Fatalf("expected distinct ids, got %#x and %#x", connA.id, connB.id)
+	}
+
+	payload := []byte("hello idA")
+	go l.dispatch(RevDialDataCmd, idA, payload)
+
+	buf := make([]byte, len(payload))
+	if _, err := io.ReadFull(connA.pr, buf); err != nil {
+		t.Fatalf("read from connA: %v", err)
+	}
+	if string(buf) != string(payload) {
+		t.Fatalf("connA got %q, want %q", buf, payload)
+	}
+
+	select {
+	case b := <-readAsync(connB.pr, len(payload)):
+		t.Fatalf("connB unexpectedly received data meant for connA: %v", b)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func acceptRevDialConn(t *testing.T, l *revDialListener) *revDialConn {
+	t.Helper()
+	select {
+	case c := <-l.acceptCh:
+		return c
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for dispatch to hand off an accepted conn")
+		return nil
+	}
+}
+
+func readAsync(r io.Reader, n int) <-chan []byte {
+	ch := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err == nil {
+			ch <- buf
+		}
+	}()
+	return ch
+}
+
+func TestRevDialListenerCloseCmdClosesConn(t *testing.T) {
+	l := newTestRevDialListener()
+	const id uint64 = 42
+
+	l.dispatch(RevDialNewConnCmd, id, nil)
+	c := acceptRevDialConn(t, l)
+
+	l.dispatch(RevDialCloseCmd, id, nil)
+
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Read after RevDialCloseCmd = %v, want io.EOF", err)
+	}
+	if _, ok := l.conns[id]; ok {
+		t.Fatal("expected forget(id) to drop the closed conn from l.conns")
+	}
+}
+
+func TestRevDialListenerCloseDrainsConns(t *testing.T) {
+	l := newTestRevDialListener()
+	l.dispatch(RevDialNewConnCmd, 1, nil)
+	c := acceptRevDialConn(t, l)
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if _, err := c.Read(make([]byte, 1)); err != ErrRevDialClosed {
+		t.Fatalf("Read after listener Close = %v, want ErrRevDialClosed", err)
+	}
+	if _, err := l.Accept(); err != ErrRevDialClosed {
+		t.Fatalf("Accept after Close = %v, want ErrRevDialClosed", err)
+	}
+}
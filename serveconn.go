@@ -39,13 +39,46 @@ type serveconn struct {
 	// This is never wrapped by other types and is the value given out
 	// to CloseNotifier callers. It is usually of type *net.TCPConn
 	rwc         net.Conn
-	wlock       sync.Mutex
 	bytesWriter *Writer
 
+	sched     WriteScheduler // see writescheduler.go; owns outbound frame ordering
+	schedWake chan struct{}  // signals the writeLoop goroutine that sched has new work
+
 	reader      *defaultFrameReader  // used in conn.readFrames
 	writer      FrameWriter          // used by handlers
 	readFrameCh chan readFrameResult // written by conn.readFrames
 
+	// flow control, see flowcontrol.go. nil connSendWindow/connRecvWindow
+	// disables flow control entirely.
+	connSendWindow          *flowWindow // bytes we may still send on this connection
+	connRecvWindow          *flowWindow // non-nil when flow control is on; inbound frames are not counted against it
+	initialStreamSendWindow int32
+	windowsMu               sync.Mutex
+	streamSendWindows       map[uint64]*flowWindow // bytes we may still send, per stream
+	manualCreditStreams     map[uint64]struct{}    // streams whose handler wrote ManualWindowUpdateFlag; see flowcontrol.go
+
+	// revDialListener is set by NewRevDialListener when the application
+	// turns this connection into a net.Listener; see revdial.go.
+	revDialListener *revDialListener
+
+	// PING/keepalive/idle-timeout bookkeeping, see ping.go.
+	activeStreams  int32 // streams opened via writeFrame and not yet self-closed
+	lastActivityNs int64 // unix nanos of the last frame read or written
+	lastReadNs     int64 // unix nanos of the last frame read
+	pingMu         sync.Mutex
+	pingWaiters    map[uint64]chan time.Time
+	pingTimers     map[uint64]*time.Timer
+
+	// sendComp/recvComp are the per-connection, per-direction compression
+	// state (see compression.go); nil when ServerBinding.Compression is
+	// CompressionNone. HPACK-style dynamic tables are direction-specific,
+	// so encode and decode state must never share a connCompressor.
+	// sendComp is only ever touched from writeLoop (the single writer
+	// goroutine, true wire order); recvComp is only touched from
+	// readFrames.
+	sendComp *connCompressor
+	recvComp *connCompressor
+
 	// modified by Server
 	untrack     uint32 // ony the first call to untrack actually do it, subsequent calls should wait for untrackedCh
 	untrackedCh chan struct{}
@@ -150,9 +183,43 @@ func (sc *serveconn) serve() {
 	sc.writer = newFrameWriter(sc) // only used by blocking mode
 	sc.bytesWriter = NewWriterWithTimeout(ctx, sc.rwc, binding.DefaultWriteTimeout)
 
+	if binding.MaxUploadBufferPerConnection >= 0 {
+		connWindow := clampWindowSize(binding.MaxUploadBufferPerConnection, defaultMaxUploadBufferPerConnection, minMaxUploadBufferPerConnection)
+		sc.connSendWindow = newFlowWindow(connWindow)
+		sc.connRecvWindow = newFlowWindow(connWindow)
+		sc.initialStreamSendWindow = clampWindowSize(binding.MaxUploadBufferPerStream, defaultMaxUploadBufferPerStream, minMaxUploadBufferPerStream)
+		sc.streamSendWindows = make(map[uint64]*flowWindow)
+		sc.manualCreditStreams = make(map[uint64]struct{})
+	}
+
+	if binding.NewWriteScheduler != nil {
+		sc.sched = binding.NewWriteScheduler()
+	} else {
+		sc.sched = NewRandomWriteScheduler()
+	}
+	sc.schedWake = make(chan struct{}, 1)
+
+	if binding.Compression != CompressionNone {
+		sc.sendComp = newConnCompressor(binding.Compression, binding.CompressionDynamicTableSize)
+		sc.recvComp = newConnCompressor(binding.Compression, binding.CompressionDynamicTableSize)
+		for _, e := range binding.CompressionStaticTable {
+			sc.sendComp.RegisterStatic(e.Cmd, e.Prefix)
+			sc.recvComp.RegisterStatic(e.Cmd, e.Prefix)
+		}
+	}
+
+	atomic.StoreInt64(&sc.lastActivityNs, time.Now().UnixNano())
+	atomic.StoreInt64(&sc.lastReadNs, time.Now().UnixNano())
+
 	GoFunc(&sc.wg, func() {
 		sc.readFrames()
 	})
+	GoFunc(&sc.wg, func() {
+		sc.writeLoop()
+	})
+	GoFunc(&sc.wg, func() {
+		sc.keepAliveLoop(&binding)
+	})
 
 	handler := binding.Handler
 
@@ -165,6 +232,9 @@ func (sc *serveconn) serve() {
 			if res.readMore != nil {
 				func() {
 					defer sc.handleRequestPanic(res.f, time.Now())
+					// runs before handleRequestPanic drops a self-closed
+					// stream's ManualWindowUpdateFlag mark
+					defer sc.returnFlowControlCredit(res.f)
 					handler.ServeQRPC(sc.writer, res.f)
 				}()
 				res.readMore()
@@ -172,6 +242,7 @@ func (sc *serveconn) serve() {
 				GoFunc(&sc.wg, func() {
 					defer sc.handleRequestPanic(res.f, time.Now())
 					handler.ServeQRPC(sc.GetWriter(), res.f)
+					sc.returnFlowControlCredit(res.f)
 				})
 			}
 		}
@@ -227,6 +298,12 @@ func (sc *serveconn) handleRequestPanic(frame *RequestFrame, begin time.Time) {
 		}
 	}
 
+	if s.IsSelfClosed() {
+		sc.releaseStreamSendWindow(frame.RequestID)
+		sc.sched.CloseStream(frame.RequestID)
+		atomic.AddInt32(&sc.activeStreams, -1)
+	}
+
 }
 
 // SetID sets id for serveconn
@@ -326,6 +403,44 @@ func (sc *serveconn) readFrames() (err error) {
 			}
 			return err
 		}
+		sc.noteRead()
+
+		if req.Flags.IsCompressed() && sc.recvComp != nil {
+			payload, decErr := sc.recvComp.Decompress(req.Cmd, req.Payload)
+			if decErr != nil {
+				LogError("decompress frame", decErr, sc.RemoteAddr())
+				sc.Close()
+				return decErr
+			}
+			req.Payload = payload
+		}
+
+		if req.Cmd == PingCmd {
+			sc.handlePing((*RequestFrame)(req))
+			continue
+		}
+
+		if req.Cmd == WindowUpdateCmd {
+			sc.handleWindowUpdate((*RequestFrame)(req))
+			continue
+		}
+
+		if sc.revDialListener != nil {
+			switch req.Cmd {
+			case RevDialNewConnCmd, RevDialDataCmd, RevDialCloseCmd:
+				if req.Cmd == RevDialDataCmd {
+					// RevDialDataCmd payload consumes flow-control window
+					// like any other frame; it bypasses the Handler path
+					// below so the credit has to be returned here instead,
+					// or sustained revdial traffic would drain the window
+					// and deadlock.
+					sc.returnFlowControlCredit((*RequestFrame)(req))
+				}
+				sc.revDialListener.dispatch(req.Cmd, req.RequestID, req.Payload)
+				continue
+			}
+		}
+
 		if req.FromServer() {
 			ci.l.Lock()
 			if ci.respes != nil {
@@ -380,35 +495,74 @@ func (sc *serveconn) writeFrame(dfw *defaultFrameWriter) (err error) {
 	default:
 	}
 
-	sc.wlock.Lock()
-
-	defer sc.wlock.Unlock()
-
 	flags := dfw.Flags()
 	requestID := dfw.RequestID()
 
-	if flags.IsRst() {
-		s := sc.cs.GetStream(requestID, flags)
-		if s == nil {
-			err = ErrRstNonExistingStream
-			return
-		}
-		// for rst frame, AddOutFrame returns false when no need to send the frame
-		if !s.AddOutFrame(requestID, flags) {
-			return
+	if dfw.Cmd() != WindowUpdateCmd && dfw.Cmd() != PingCmd && !flags.IsRst() {
+		payloadLen := int32(len(dfw.GetWbuf())) - frameHeaderLen
+		if err = sc.takeSendWindow(requestID, payloadLen); err != nil {
+			return err
 		}
-	} else if !flags.IsPush() { // skip stream logic if PushFlag set
-		s, loaded := sc.cs.CreateOrGetStream(sc.ctx, requestID, flags)
-		if !loaded {
-			LogDebug(unsafe.Pointer(sc.cs), "serveconn new stream", requestID, flags, dfw.Cmd())
+	}
+
+	// Connection-level control frames (PING, connection WINDOW_UPDATE)
+	// carry RequestID == 0 but aren't a real "stream 0": they must skip
+	// stream bookkeeping entirely, or every PING/conn-credit frame would
+	// fabricate a permanent phantom stream that never self-closes and
+	// so never lets activeStreams (and thus IdleTimeout) reach zero.
+	isControlFrame := requestID == 0 && (dfw.Cmd() == PingCmd || dfw.Cmd() == WindowUpdateCmd)
+
+	if !isControlFrame && requestID != 0 && flags.IsManualWindowUpdate() {
+		sc.windowsMu.Lock()
+		if sc.manualCreditStreams != nil {
+			sc.manualCreditStreams[requestID] = struct{}{}
 		}
-		if !s.AddOutFrame(requestID, flags) {
-			err = ErrWriteAfterCloseSelf
-			return
+		sc.windowsMu.Unlock()
+	}
+
+	if !isControlFrame {
+		if flags.IsRst() {
+			s := sc.cs.GetStream(requestID, flags)
+			if s == nil {
+				err = ErrRstNonExistingStream
+				return
+			}
+			// for rst frame, AddOutFrame returns false when no need to send the frame
+			if !s.AddOutFrame(requestID, flags) {
+				return
+			}
+		} else if !flags.IsPush() { // skip stream logic if PushFlag set
+			s, loaded := sc.cs.CreateOrGetStream(sc.ctx, requestID, flags)
+			if !loaded {
+				LogDebug(unsafe.Pointer(sc.cs), "serveconn new stream", requestID, flags, dfw.Cmd())
+				priority := PriorityParam{Weight: 15}
+				if p := dfw.Priority(); p != nil {
+					priority = *p
+				}
+				sc.sched.OpenStream(requestID, priority)
+				atomic.AddInt32(&sc.activeStreams, 1)
+			}
+			if !s.AddOutFrame(requestID, flags) {
+				err = ErrWriteAfterCloseSelf
+				return
+			}
 		}
 	}
 
-	_, err = sc.bytesWriter.Write(dfw.GetWbuf())
+	b := append([]byte(nil), dfw.GetWbuf()...)
+	wr := FrameWriteRequest{streamID: requestID, b: b, done: make(chan error, 1)}
+	sc.sched.Push(wr)
+	select {
+	case sc.schedWake <- struct{}{}:
+	default:
+	}
+
+	select {
+	case err = <-wr.done:
+	case <-sc.ctx.Done():
+		return sc.ctx.Err()
+	}
+
 	if err != nil {
 		LogDebug(unsafe.Pointer(sc), "serveconn Write", err)
 		sc.Close()
@@ -432,6 +586,63 @@ func (sc *serveconn) writeFrame(dfw *defaultFrameWriter) (err error) {
 	return
 }
 
+// writeLoop is the single goroutine permitted to write to sc.rwc. It
+// pops frames from sc.sched in the order the scheduler chooses and
+// flushes them, decoupling write ordering from the many goroutines
+// that may call writeFrame concurrently. Compressing here, rather than
+// when the frame is first written by its handler goroutine, matters
+// for CompressionHPACKLike: its dynamic table is learned in wire order,
+// so mutating it anywhere but this single goroutine would race and let
+// the two ends' tables drift apart.
+func (sc *serveconn) writeLoop() {
+	for {
+		wr, ok := sc.sched.Pop()
+		if !ok {
+			select {
+			case <-sc.schedWake:
+				continue
+			case <-sc.ctx.Done():
+				return
+			}
+		}
+
+		b := sc.compressFrame(wr.b)
+
+		_, err := sc.bytesWriter.Write(b)
+		if err == nil {
+			sc.noteActivity()
+		}
+		wr.done <- err
+	}
+}
+
+// compressFrame returns b, a fully-framed (header+payload) outbound
+// frame, with its payload replaced by sc.sendComp's compressed encoding
+// when that's smaller. Only called from writeLoop.
+func (sc *serveconn) compressFrame(b []byte) []byte {
+	if sc.sendComp == nil || len(b) <= frameHeaderLen {
+		return b
+	}
+	flags := FrameFlag(b[12])
+	if flags.IsCompressed() {
+		return b
+	}
+	cmd := Cmd(uint32(b[13])<<16 | uint32(b[14])<<8 | uint32(b[15]))
+
+	encoded, ok := sc.sendComp.Compress(cmd, b[frameHeaderLen:])
+	if !ok {
+		return b
+	}
+
+	nb := make([]byte, frameHeaderLen+len(encoded))
+	copy(nb, b[:frameHeaderLen])
+	copy(nb[frameHeaderLen:], encoded)
+	length := len(nb) - 4
+	nb[0], nb[1], nb[2], nb[3] = byte(length>>24), byte(length>>16), byte(length>>8), byte(length)
+	nb[12] = byte(flags.ToCompressed())
+	return nb
+}
+
 // Request clientconn from serveconn
 func (sc *serveconn) Request(cmd Cmd, flags FrameFlag, payload []byte) (uint64, Response, error) {
 	flags = flags | NBFlag
@@ -520,6 +731,7 @@ func (sc *serveconn) closeUntracked() error {
 		return err
 	}
 	sc.cancelCtx()
+	sc.stopPingTimers()
 
 	ci := sc.ctx.Value(ConnectionInfoKey).(*ConnectionInfo)
 	ci.l.Lock()
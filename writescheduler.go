@@ -0,0 +1,306 @@
+package qrpc
+
+import "sync"
+
+// PriorityParam describes a stream's place in the priority tree, modeled
+// on HTTP/2's HEADERS priority fields. Weight is 1-256; a stream with no
+// explicit dependency depends on the root (StreamDep == 0).
+type PriorityParam struct {
+	StreamDep uint64
+	Weight    uint8 // actual weight is Weight+1, i.e. 1-256
+	Exclusive bool
+}
+
+// FrameWriteRequest is one scheduler-managed write: the already-framed
+// bytes for a single frame, plus a channel the scheduler's consumer
+// signals once the bytes have been flushed (or writing failed).
+type FrameWriteRequest struct {
+	streamID uint64
+	b        []byte
+	done     chan error
+}
+
+// WriteScheduler decides the order in which queued frames for a
+// serveconn are written to the wire. Implementations must be safe for
+// concurrent Push from many request-serving goroutines and a single
+// Pop from the connection's writer goroutine.
+type WriteScheduler interface {
+	// Push enqueues a frame ready to be written.
+	Push(FrameWriteRequest)
+	// Pop removes and returns the next frame to write, if any is ready.
+	Pop() (FrameWriteRequest, bool)
+	// OpenStream registers a new stream with the given priority.
+	OpenStream(streamID uint64, priority PriorityParam)
+	// CloseStream forgets a stream and any of its queued frames.
+	CloseStream(streamID uint64)
+	// AdjustStream reparents/reweights an already open stream.
+	AdjustStream(streamID uint64, priority PriorityParam)
+}
+
+// NewRandomWriteScheduler returns a WriteScheduler that interleaves
+// frames from different streams in round-robin order, so one large
+// streamed response can't starve small requests behind a single write
+// mutex.
+func NewRandomWriteScheduler() WriteScheduler {
+	return &roundRobinWriteScheduler{queues: make(map[uint64][]FrameWriteRequest)}
+}
+
+type roundRobinWriteScheduler struct {
+	mu     sync.Mutex
+	order  []uint64 // stream ids with pending frames, in round-robin order
+	queues map[uint64][]FrameWriteRequest
+}
+
+func (s *roundRobinWriteScheduler) Push(wr FrameWriteRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.queues[wr.streamID]
+	if !ok {
+		s.order = append(s.order, wr.streamID)
+	}
+	s.queues[wr.streamID] = append(q, wr)
+}
+
+func (s *roundRobinWriteScheduler) Pop() (FrameWriteRequest, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.order) > 0 {
+		id := s.order[0]
+		q := s.queues[id]
+		if len(q) == 0 {
+			s.order = s.order[1:]
+			delete(s.queues, id)
+			continue
+		}
+		wr := q[0]
+		if len(q) == 1 {
+			delete(s.queues, id)
+			s.order = s.order[1:]
+		} else {
+			s.queues[id] = q[1:]
+			s.order = append(s.order[1:], id)
+		}
+		return wr, true
+	}
+	return FrameWriteRequest{}, false
+}
+
+func (s *roundRobinWriteScheduler) OpenStream(streamID uint64, priority PriorityParam) {}
+
+func (s *roundRobinWriteScheduler) CloseStream(streamID uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.queues, streamID)
+	for i, id := range s.order {
+		if id == streamID {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *roundRobinWriteScheduler) AdjustStream(streamID uint64, priority PriorityParam) {}
+
+// NewPriorityWriteScheduler returns a WriteScheduler that honours a
+// per-stream weight (1-256) and parent-dependency tree: bandwidth is
+// distributed to sibling streams proportionally to weight, and a
+// stream's children inherit its share while it is idle, mirroring
+// x/net/http2's priorityWriteScheduler.
+func NewPriorityWriteScheduler() WriteScheduler {
+	s := &priorityWriteScheduler{nodes: make(map[uint64]*priorityNode)}
+	s.nodes[0] = &priorityNode{id: 0, weight: 255}
+	return s
+}
+
+type priorityNode struct {
+	id       uint64
+	parent   uint64
+	weight   uint8
+	cw       int // current weight; see pickWeightedChild
+	children []uint64
+	queue    []FrameWriteRequest
+}
+
+type priorityWriteScheduler struct {
+	mu    sync.Mutex
+	nodes map[uint64]*priorityNode
+}
+
+func (s *priorityWriteScheduler) node(id uint64) *priorityNode {
+	n, ok := s.nodes[id]
+	if !ok {
+		n = &priorityNode{id: id, weight: 15}
+		s.nodes[id] = n
+		s.linkToParent(n, 0)
+	}
+	return n
+}
+
+func (s *priorityWriteScheduler) linkToParent(n *priorityNode, parent uint64) {
+	n.parent = parent
+	if p, ok := s.nodes[parent]; ok {
+		p.children = append(p.children, n.id)
+	}
+}
+
+func (s *priorityWriteScheduler) Push(wr FrameWriteRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.node(wr.streamID)
+	n.queue = append(n.queue, wr)
+}
+
+// Pop walks the tree depth-first from the root, at each level picking
+// among children with pending work (directly queued, or via a
+// non-empty descendant) weighted by priorityNode.weight. A stream with
+// nothing of its own queued is skipped in favor of its children, so
+// idle parents don't block their subtree.
+func (s *priorityWriteScheduler) Pop() (FrameWriteRequest, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := uint64(0)
+	for {
+		n := s.nodes[id]
+		if n == nil {
+			return FrameWriteRequest{}, false
+		}
+		if len(n.queue) > 0 {
+			wr := n.queue[0]
+			n.queue = n.queue[1:]
+			return wr, true
+		}
+		next, ok := pickWeightedChild(s.nodes, n)
+		if !ok {
+			return FrameWriteRequest{}, false
+		}
+		id = next
+	}
+}
+
+// pickWeightedChild selects among n's children that have pending work
+// (directly queued, or via a non-empty descendant) using Nginx's
+// smooth weighted round-robin: each eligible child's running credit
+// (cw) is bumped by its own (weight+1) every call, the child with the
+// highest resulting credit is chosen, and that child's credit is then
+// reduced by the sum of (weight+1) over all eligible children. Unlike
+// picking the single highest-weight child every time, this interleaves
+// siblings proportionally to their weights (e.g. weights 4:1 yield
+// A,A,B,A,A,... rather than starving B for as long as A has work), and
+// ties break by id for determinism. Credit only accrues to children
+// currently eligible, so a child that only just got work competes on
+// equal footing rather than cashing in credit banked while idle.
+func pickWeightedChild(nodes map[uint64]*priorityNode, n *priorityNode) (uint64, bool) {
+	var eligible []*priorityNode
+	total := 0
+	for _, cid := range n.children {
+		c, ok := nodes[cid]
+		if !ok || !subtreeHasWork(nodes, c) {
+			continue
+		}
+		eligible = append(eligible, c)
+		total += int(c.weight) + 1
+	}
+	if len(eligible) == 0 {
+		return 0, false
+	}
+
+	var best *priorityNode
+	for _, c := range eligible {
+		c.cw += int(c.weight) + 1
+		if best == nil || c.cw > best.cw || (c.cw == best.cw && c.id < best.id) {
+			best = c
+		}
+	}
+	best.cw -= total
+	return best.id, true
+}
+
+func subtreeHasWork(nodes map[uint64]*priorityNode, n *priorityNode) bool {
+	if len(n.queue) > 0 {
+		return true
+	}
+	for _, cid := range n.children {
+		if c, ok := nodes[cid]; ok && subtreeHasWork(nodes, c) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *priorityWriteScheduler) OpenStream(streamID uint64, priority PriorityParam) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := &priorityNode{id: streamID, weight: priority.Weight}
+	s.nodes[streamID] = n
+	parent := priority.StreamDep
+	if _, ok := s.nodes[parent]; !ok {
+		parent = 0
+	}
+	if priority.Exclusive {
+		if p := s.nodes[parent]; p != nil {
+			n.children = p.children
+			for _, cid := range n.children {
+				if c, ok := s.nodes[cid]; ok {
+					c.parent = streamID
+				}
+			}
+			p.children = nil
+		}
+	}
+	s.linkToParent(n, parent)
+}
+
+func (s *priorityWriteScheduler) CloseStream(streamID uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodes[streamID]
+	if !ok {
+		return
+	}
+	delete(s.nodes, streamID)
+	if p, ok := s.nodes[n.parent]; ok {
+		p.children = append(p.children, n.children...)
+		for i, cid := range p.children {
+			if cid == streamID {
+				p.children = append(p.children[:i], p.children[i+1:]...)
+				break
+			}
+		}
+	}
+	for _, cid := range n.children {
+		if c, ok := s.nodes[cid]; ok {
+			c.parent = n.parent
+		}
+	}
+}
+
+func (s *priorityWriteScheduler) AdjustStream(streamID uint64, priority PriorityParam) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodes[streamID]
+	if !ok {
+		s.mu.Unlock()
+		s.OpenStream(streamID, priority)
+		s.mu.Lock()
+		return
+	}
+	n.weight = priority.Weight
+	n.cw = 0
+	if n.parent == priority.StreamDep {
+		return
+	}
+	if p, ok := s.nodes[n.parent]; ok {
+		for i, cid := range p.children {
+			if cid == streamID {
+				p.children = append(p.children[:i], p.children[i+1:]...)
+				break
+			}
+		}
+	}
+	parent := priority.StreamDep
+	if _, ok := s.nodes[parent]; !ok {
+		parent = 0
+	}
+	s.linkToParent(n, parent)
+}
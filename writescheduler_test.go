@@ -0,0 +1,151 @@
+package qrpc
+
+import "testing"
+
+func wr(streamID uint64) FrameWriteRequest {
+	return FrameWriteRequest{streamID: streamID}
+}
+
+func TestRoundRobinWriteSchedulerFairness(t *testing.T) {
+	s := NewRandomWriteScheduler()
+
+	s.Push(wr(1))
+	s.Push(wr(2))
+	s.Push(wr(1))
+	s.Push(wr(3))
+	s.Push(wr(2))
+
+	var order []uint64
+	for {
+		got, ok := s.Pop()
+		if !ok {
+			break
+		}
+		order = append(order, got.streamID)
+	}
+
+	want := []uint64{1, 2, 3, 1, 2}
+	if len(order) != len(want) {
+		t.Fatalf("got %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got %v, want %v", order, want)
+		}
+	}
+}
+
+func TestRoundRobinWriteSchedulerCloseStream(t *testing.T) {
+	s := NewRandomWriteScheduler()
+	s.Push(wr(1))
+	s.Push(wr(2))
+	s.CloseStream(1)
+
+	got, ok := s.Pop()
+	if !ok || got.streamID != 2 {
+		t.Fatalf("Pop() = %v, %v, want stream 2", got, ok)
+	}
+	if _, ok := s.Pop(); ok {
+		t.Fatal("expected no more pending frames after closing stream 1")
+	}
+}
+
+func TestPriorityWriteSchedulerWeightedOrder(t *testing.T) {
+	s := NewPriorityWriteScheduler()
+	// stream 1 gets 3x the weight of stream 2, both children of the root.
+	s.OpenStream(1, PriorityParam{Weight: 191}) // weight 192
+	s.OpenStream(2, PriorityParam{Weight: 63})  // weight 64
+
+	for i := 0; i < 3; i++ {
+		s.Push(wr(1))
+	}
+	for i := 0; i < 1; i++ {
+		s.Push(wr(2))
+	}
+
+	counts := map[uint64]int{}
+	for {
+		got, ok := s.Pop()
+		if !ok {
+			break
+		}
+		counts[got.streamID]++
+	}
+
+	if counts[1] != 3 || counts[2] != 1 {
+		t.Fatalf("counts = %v, want {1:3, 2:1}", counts)
+	}
+}
+
+func TestPriorityWriteSchedulerWeightedInterleaving(t *testing.T) {
+	s := NewPriorityWriteScheduler()
+	// stream 1 gets 3x the weight of stream 2, both children of the root.
+	s.OpenStream(1, PriorityParam{Weight: 2}) // weight 3
+	s.OpenStream(2, PriorityParam{Weight: 0}) // weight 1
+
+	// Both streams stay continuously saturated: whatever Pop just
+	// returned is immediately refilled, so this observes interleaving
+	// rather than just a final drained total.
+	s.Push(wr(1))
+	s.Push(wr(2))
+
+	const n = 20
+	var order []uint64
+	counts := map[uint64]int{}
+	for i := 0; i < n; i++ {
+		got, ok := s.Pop()
+		if !ok {
+			t.Fatalf("Pop() #%d: no frame ready, want one (both streams stay saturated)", i)
+		}
+		order = append(order, got.streamID)
+		counts[got.streamID]++
+		s.Push(wr(got.streamID))
+	}
+
+	if counts[1] != 15 || counts[2] != 5 {
+		t.Fatalf("counts over %d pops = %v, want {1:15, 2:5} (weight-proportional share)", n, counts)
+	}
+
+	// A scheduler that always picks the highest-weight child would
+	// starve stream 2 for all 20 calls; weighted round robin must
+	// interleave it well before then.
+	run := 0
+	for _, id := range order {
+		if id == 1 {
+			run++
+			if run > 3 {
+				t.Fatalf("order = %v: stream 1 ran %d times in a row without stream 2, looks like starvation", order, run)
+			}
+		} else {
+			run = 0
+		}
+	}
+}
+
+func TestPriorityWriteSchedulerIdleParentSkipped(t *testing.T) {
+	s := NewPriorityWriteScheduler()
+	s.OpenStream(1, PriorityParam{Weight: 15})
+	s.OpenStream(2, PriorityParam{StreamDep: 1, Weight: 15})
+
+	// Stream 1 (the parent) has nothing queued; its child's frame must
+	// still be served rather than Pop reporting no work.
+	s.Push(wr(2))
+
+	got, ok := s.Pop()
+	if !ok || got.streamID != 2 {
+		t.Fatalf("Pop() = %v, %v, want stream 2", got, ok)
+	}
+}
+
+func TestPriorityWriteSchedulerCloseStreamReparentsChildren(t *testing.T) {
+	s := NewPriorityWriteScheduler()
+	s.OpenStream(1, PriorityParam{Weight: 15})
+	s.OpenStream(2, PriorityParam{StreamDep: 1, Weight: 15})
+	s.CloseStream(1)
+	s.Push(wr(2))
+
+	got, ok := s.Pop()
+	if !ok || got.streamID != 2 {
+		t.Fatalf("Pop() after closing parent = %v, %v, want stream 2", got, ok)
+	}
+}